@@ -1,21 +1,32 @@
 package data
 
 import (
-	"encoding/xml"
 	"fmt"
-	"net/http"
-	"strconv"
+	"sync"
 
 	"github.com/hashicorp/go-hclog"
 )
 
+// ExchangeRates holds an in memory map of currency rates, keyed on currency
+// code, each value the EUR relative rate reported by the configured
+// RateSource. rates is written by
+// GetRates (called both at startup and from Refresher's background
+// goroutine) and read concurrently by every GetRate/Health caller across the
+// gRPC, jsonrpc and websocket transports, so access is guarded by mu.
 type ExchangeRates struct {
-	log hclog.Logger
+	log    hclog.Logger
+	source RateSource
+
+	mu    sync.RWMutex
 	rates map[string]float64
 }
 
-func NewRates(l hclog.Logger) (*ExchangeRates, error) {
-	er := &ExchangeRates{log: l, rates: map[string]float64{}}
+// NewRates creates a new ExchangeRates backed by source and does an initial
+// fetch. If the fetch fails the returned ExchangeRates is still usable (with
+// an empty rate map) so callers can decide whether to retry via Refresher
+// rather than failing startup outright.
+func NewRates(l hclog.Logger, source RateSource) (*ExchangeRates, error) {
+	er := &ExchangeRates{log: l, source: source, rates: map[string]float64{}}
 
 	err := er.GetRates()
 
@@ -23,46 +34,51 @@ func NewRates(l hclog.Logger) (*ExchangeRates, error) {
 }
 
 func (e *ExchangeRates) GetRate(base string, dest string) (float64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	br, ok := e.rates[base]
 	if !ok {
-		return 0,fmt.Errorf("rate not found for currency %s", base)
+		return 0, fmt.Errorf("rate not found for currency %s", base)
 	}
 
 	dr, ok := e.rates[dest]
 	if !ok {
-		return 0,fmt.Errorf("rate not found for currency %s", dest)
+		return 0, fmt.Errorf("rate not found for currency %s", dest)
 	}
 
-	return dr / br , nil
+	return dr / br, nil
 }
 
+// GetRates fetches the latest rates from the configured source and, on
+// success, replaces the in memory map. A failed fetch leaves the previously
+// known rates untouched so a transient outage doesn't wipe out GetRate.
 func (e *ExchangeRates) GetRates() error {
-	resp, err :=  http.DefaultClient.Get("https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml")
+	rates, err := e.source.Fetch()
 	if err != nil {
-		return nil
+		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("expected a status of 200, but got %d", resp.StatusCode)
-	}
-
-	defer resp.Body.Close()
-
-	md := &Cubes{}
-	xml.NewDecoder(resp.Body).Decode(&md)
+	e.mu.Lock()
+	e.rates = rates
+	e.mu.Unlock()
 
-	for _,c := range md.CubeData {
-		r, err := strconv.ParseFloat(c.Rate, 64)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		e.rates[c.Currency] = r
+// Health reports the status of the underlying rate source(s). Sources which
+// don't track per-source health (anything other than MultiSource) report a
+// single entry that is healthy whenever any rates are held, so a failed
+// GetRates that left earlier rates in place still reports healthy.
+func (e *ExchangeRates) Health() []SourceHealth {
+	if hs, ok := e.source.(interface{ Health() []SourceHealth }); ok {
+		return hs.Health()
 	}
 
-	e.rates["EUR"] = 1
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 
-	return nil
+	return []SourceHealth{{Name: e.source.Name(), Healthy: len(e.rates) > 0}}
 }
 
 type Cubes struct {
@@ -71,5 +87,5 @@ type Cubes struct {
 
 type Cube struct {
 	Currency string `xml:"currency,attr"`
-	Rate string `xml:"rate,attr"`
-}
\ No newline at end of file
+	Rate     string `xml:"rate,attr"`
+}
@@ -0,0 +1,275 @@
+package data
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// BackoffConfig describes an exponential backoff with jitter, the same shape
+// as gRPC's default backoff config: delays start at BaseDelay and grow by
+// Factor on each consecutive failure up to MaxDelay, with a +/-Jitter
+// fraction applied to avoid thundering herds of retries.
+type BackoffConfig struct {
+	BaseDelay time.Duration
+	Factor    float64
+	MaxDelay  time.Duration
+	Jitter    float64
+}
+
+// DefaultBackoff is used by Refresher when no BackoffConfig is supplied.
+var DefaultBackoff = BackoffConfig{
+	BaseDelay: time.Second,
+	Factor:    1.6,
+	MaxDelay:  120 * time.Second,
+	Jitter:    0.2,
+}
+
+// delay returns the backoff duration for the given consecutive failure
+// count (1-indexed), with jitter applied.
+func (b BackoffConfig) delay(failures int) time.Duration {
+	d := float64(b.BaseDelay)
+	for i := 1; i < failures; i++ {
+		d *= b.Factor
+		if d > float64(b.MaxDelay) {
+			d = float64(b.MaxDelay)
+			break
+		}
+	}
+
+	if b.Jitter > 0 {
+		delta := d * b.Jitter
+		d = d - delta + rand.Float64()*2*delta
+	}
+
+	return time.Duration(d)
+}
+
+// CircuitState describes whether a CircuitBreaker is allowing calls through.
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+)
+
+// CircuitBreaker opens after Threshold consecutive failures and stays open
+// for Cooldown before allowing another attempt through, so a persistently
+// failing upstream doesn't get hammered on every backoff tick.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	state    CircuitState
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a CircuitBreaker that opens after threshold
+// consecutive failures and cools down for the given duration.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow reports whether a call should be attempted. Once the cooldown
+// elapses it returns true without resetting the breaker, and keeps doing so
+// until RecordSuccess closes it or RecordFailure restarts the cooldown.
+func (c *CircuitBreaker) Allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == CircuitClosed {
+		return true
+	}
+
+	return time.Since(c.openedAt) >= c.Cooldown
+}
+
+// RecordSuccess resets the breaker to closed.
+func (c *CircuitBreaker) RecordSuccess() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures = 0
+	c.state = CircuitClosed
+}
+
+// RecordFailure registers a failure and returns true if this call caused the
+// breaker to (re)open.
+func (c *CircuitBreaker) RecordFailure() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures++
+	if c.state == CircuitClosed && c.failures >= c.Threshold {
+		c.state = CircuitOpen
+		c.openedAt = time.Now()
+		return true
+	}
+
+	if c.state == CircuitOpen {
+		c.openedAt = time.Now()
+		return true
+	}
+
+	return false
+}
+
+// State returns the current breaker state.
+func (c *CircuitBreaker) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// UpdateState describes the outcome of a single Refresher tick, emitted on
+// the channel returned by MonitorRates so subscribers can tell a genuine
+// rate change apart from a retry or a circuit trip.
+type UpdateState int
+
+const (
+	// StateUpdated means GetRates succeeded and the in memory rates were
+	// replaced with the fetched set (which may hold the same values).
+	StateUpdated UpdateState = iota
+	// StateStale means GetRates failed; the previously known rates are
+	// still being served.
+	StateStale
+	// StateCircuitOpen means consecutive failures tripped the circuit
+	// breaker; the source is not being polled again until the cooldown
+	// elapses.
+	StateCircuitOpen
+	// StateCircuitClosed means the breaker recovered after having been
+	// open.
+	StateCircuitClosed
+)
+
+// RateUpdate is emitted on the channel returned by MonitorRates on every
+// refresh attempt.
+type RateUpdate struct {
+	State UpdateState
+	Err   error
+}
+
+// Refresher polls an ExchangeRates source on a healthy interval, backing off
+// exponentially (with jitter) on failure and tripping a CircuitBreaker after
+// repeated consecutive failures so the upstream isn't hammered during an
+// outage.
+type Refresher struct {
+	rates    *ExchangeRates
+	log      hclog.Logger
+	interval time.Duration
+	backoff  BackoffConfig
+	breaker  *CircuitBreaker
+}
+
+// NewRefresher creates a Refresher that polls rates every interval when
+// healthy, using DefaultBackoff and a breaker that opens after 5 consecutive
+// failures with a 60s cooldown.
+func NewRefresher(rates *ExchangeRates, l hclog.Logger, interval time.Duration) *Refresher {
+	return &Refresher{
+		rates:    rates,
+		log:      l,
+		interval: interval,
+		backoff:  DefaultBackoff,
+		breaker:  NewCircuitBreaker(5, 60*time.Second),
+	}
+}
+
+// Run starts polling in a background goroutine and returns a channel of
+// RateUpdate events. The goroutine exits and the channel is closed when ctx
+// is cancelled.
+func (r *Refresher) Run(ctx context.Context) <-chan RateUpdate {
+	ru := make(chan RateUpdate)
+
+	go func() {
+		defer close(ru)
+
+		failures := 0
+
+		for {
+			if !r.breaker.Allow() {
+				if !r.send(ctx, ru, RateUpdate{State: StateCircuitOpen}) {
+					return
+				}
+				if !r.sleep(ctx, r.breaker.Cooldown) {
+					return
+				}
+				continue
+			}
+
+			wasOpen := r.breaker.State() == CircuitOpen
+
+			err := r.rates.GetRates()
+			if err != nil {
+				failures++
+				r.log.Error("Unable to refresh rates", "error", err, "consecutive_failures", failures)
+
+				state := StateStale
+				if r.breaker.RecordFailure() {
+					state = StateCircuitOpen
+				}
+
+				if !r.send(ctx, ru, RateUpdate{State: state, Err: err}) {
+					return
+				}
+				if !r.sleep(ctx, r.backoff.delay(failures)) {
+					return
+				}
+				continue
+			}
+
+			failures = 0
+			r.breaker.RecordSuccess()
+
+			if wasOpen {
+				if !r.send(ctx, ru, RateUpdate{State: StateCircuitClosed}) {
+					return
+				}
+			}
+
+			if !r.send(ctx, ru, RateUpdate{State: StateUpdated}) {
+				return
+			}
+			if !r.sleep(ctx, r.interval) {
+				return
+			}
+		}
+	}()
+
+	return ru
+}
+
+func (r *Refresher) send(ctx context.Context, ru chan<- RateUpdate, u RateUpdate) bool {
+	select {
+	case ru <- u:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (r *Refresher) sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// MonitorRates starts a Refresher against e and returns a channel of
+// RateUpdate events: one per refresh attempt, one per cooldown wait while the
+// breaker is open, and an extra StateCircuitClosed when it recovers. Polling
+// stops and the channel is closed when ctx is cancelled.
+func (e *ExchangeRates) MonitorRates(ctx context.Context, interval time.Duration) <-chan RateUpdate {
+	rf := NewRefresher(e, e.log, interval)
+	return rf.Run(ctx)
+}
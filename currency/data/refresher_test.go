@@ -0,0 +1,114 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBackoffConfigDelay checks delay's growth and clamping. Jitter is 0
+// here so exact durations can be asserted; TestBackoffConfigDelayJitter
+// covers the jitter bounds.
+func TestBackoffConfigDelay(t *testing.T) {
+	b := BackoffConfig{
+		BaseDelay: time.Second,
+		Factor:    2,
+		MaxDelay:  10 * time.Second,
+		Jitter:    0,
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{"first failure returns base delay", 1, time.Second},
+		{"grows by factor per consecutive failure", 2, 2 * time.Second},
+		{"grows by factor per consecutive failure", 3, 4 * time.Second},
+		{"grows by factor per consecutive failure", 4, 8 * time.Second},
+		{"clamps at MaxDelay", 5, 10 * time.Second},
+		{"stays clamped at MaxDelay", 10, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := b.delay(tt.failures)
+			if got != tt.want {
+				t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBackoffConfigDelayJitter checks that a non-zero Jitter keeps delay
+// within +/-Jitter of the unjittered value instead of asserting an exact
+// duration.
+func TestBackoffConfigDelayJitter(t *testing.T) {
+	b := BackoffConfig{
+		BaseDelay: 10 * time.Second,
+		Factor:    1,
+		MaxDelay:  time.Minute,
+		Jitter:    0.2,
+	}
+
+	base := float64(b.BaseDelay)
+	lower := time.Duration(base * 0.8)
+	upper := time.Duration(base * 1.2)
+
+	for i := 0; i < 100; i++ {
+		got := b.delay(1)
+		if got < lower || got > upper {
+			t.Fatalf("delay(1) = %s, want within [%s, %s]", got, lower, upper)
+		}
+	}
+}
+
+// TestCircuitBreakerStateTransitions walks a CircuitBreaker through
+// closed -> open -> cooldown probe -> closed, the same sequence Refresher.Run
+// drives it through.
+func TestCircuitBreakerStateTransitions(t *testing.T) {
+	const threshold = 3
+	const cooldown = 20 * time.Millisecond
+
+	cb := NewCircuitBreaker(threshold, cooldown)
+
+	if !cb.Allow() {
+		t.Fatal("a fresh breaker should allow calls")
+	}
+	if cb.State() != CircuitClosed {
+		t.Fatalf("a fresh breaker should be closed, got %v", cb.State())
+	}
+
+	for i := 1; i < threshold; i++ {
+		if opened := cb.RecordFailure(); opened {
+			t.Fatalf("RecordFailure should not open the breaker before threshold, failure %d", i)
+		}
+		if cb.State() != CircuitClosed {
+			t.Fatalf("breaker should stay closed before threshold, failure %d", i)
+		}
+	}
+
+	if opened := cb.RecordFailure(); !opened {
+		t.Fatal("RecordFailure should open the breaker on reaching threshold")
+	}
+	if cb.State() != CircuitOpen {
+		t.Fatalf("breaker should be open after threshold failures, got %v", cb.State())
+	}
+	if cb.Allow() {
+		t.Fatal("an open breaker should not allow calls before cooldown elapses")
+	}
+
+	time.Sleep(cooldown + 5*time.Millisecond)
+
+	if !cb.Allow() {
+		t.Fatal("an open breaker should allow a probe call once cooldown elapses")
+	}
+
+	cb.RecordSuccess()
+
+	if cb.State() != CircuitClosed {
+		t.Fatalf("RecordSuccess should close the breaker, got %v", cb.State())
+	}
+	if !cb.Allow() {
+		t.Fatal("a closed breaker should allow calls")
+	}
+}
@@ -0,0 +1,298 @@
+package data
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// RateSource is implemented by anything that can produce a map of currency
+// code to EUR-relative rate. NewRates takes a single RateSource (use
+// NewMultiSource to compose several) so the ECB XML feed, a JSON provider, or
+// a static file can be swapped in without touching ExchangeRates itself.
+type RateSource interface {
+	// Fetch returns the latest set of rates, or an error if the source is
+	// unable to produce one.
+	Fetch() (map[string]float64, error)
+	// Name identifies the source for logging and health reporting.
+	Name() string
+}
+
+// SourceHealth describes the last known state of a RateSource.
+type SourceHealth struct {
+	Name      string
+	Healthy   bool
+	LastError string
+	CheckedAt time.Time
+}
+
+// ECBSource fetches the 90 day history feed from the European Central Bank
+// and caches the last successful payload on disk so a restart (or an ECB
+// outage) doesn't leave ExchangeRates empty. Subsequent requests are made
+// conditional with ETag/If-Modified-Since so an unchanged feed is not
+// re-downloaded.
+type ECBSource struct {
+	log       hclog.Logger
+	client    *http.Client
+	url       string
+	cachePath string
+
+	etag         string
+	lastModified string
+}
+
+// NewECBSource creates a RateSource backed by the ECB XML feed. cachePath is
+// where the last successful response body is persisted; pass "" to disable
+// the on-disk cache.
+func NewECBSource(l hclog.Logger, cachePath string) *ECBSource {
+	return &ECBSource{
+		log:       l,
+		client:    http.DefaultClient,
+		url:       "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml",
+		cachePath: cachePath,
+	}
+}
+
+func (e *ECBSource) Name() string { return "ecb" }
+
+func (e *ECBSource) Fetch() (map[string]float64, error) {
+	req, err := http.NewRequest(http.MethodGet, e.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.etag != "" {
+		req.Header.Set("If-None-Match", e.etag)
+	}
+	if e.lastModified != "" {
+		req.Header.Set("If-Modified-Since", e.lastModified)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return e.fromCache(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		e.log.Debug("ECB feed not modified, using cached rates")
+		return e.fromCache(nil)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return e.fromCache(fmt.Errorf("expected a status of 200, but got %d", resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return e.fromCache(err)
+	}
+
+	rates, err := parseCubes(body)
+	if err != nil {
+		return e.fromCache(err)
+	}
+
+	e.etag = resp.Header.Get("ETag")
+	e.lastModified = resp.Header.Get("Last-Modified")
+	e.persist(body)
+
+	return rates, nil
+}
+
+// fromCache falls back to the last payload persisted to cachePath. origErr is
+// returned unchanged if no cached payload is available.
+func (e *ECBSource) fromCache(origErr error) (map[string]float64, error) {
+	if e.cachePath == "" {
+		if origErr != nil {
+			return nil, origErr
+		}
+		return nil, fmt.Errorf("no cached rates available")
+	}
+
+	body, err := ioutil.ReadFile(e.cachePath)
+	if err != nil {
+		if origErr != nil {
+			return nil, origErr
+		}
+		return nil, err
+	}
+
+	return parseCubes(body)
+}
+
+func (e *ECBSource) persist(body []byte) {
+	if e.cachePath == "" {
+		return
+	}
+
+	if err := ioutil.WriteFile(e.cachePath, body, 0644); err != nil {
+		e.log.Warn("Unable to persist ECB rates cache", "path", e.cachePath, "error", err)
+	}
+}
+
+func parseCubes(body []byte) (map[string]float64, error) {
+	md := &Cubes{}
+	if err := xml.Unmarshal(body, md); err != nil {
+		return nil, err
+	}
+
+	rates := map[string]float64{}
+	for _, c := range md.CubeData {
+		r, err := strconv.ParseFloat(c.Rate, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		rates[c.Currency] = r
+	}
+	rates["EUR"] = 1
+
+	return rates, nil
+}
+
+// JSONSource fetches rates from an HTTP endpoint that returns a flat JSON
+// object of currency code to rate, e.g. {"USD": 1.08, "GBP": 0.86}.
+type JSONSource struct {
+	client *http.Client
+	url    string
+}
+
+// NewJSONSource creates a RateSource backed by a JSON endpoint.
+func NewJSONSource(url string) *JSONSource {
+	return &JSONSource{client: http.DefaultClient, url: url}
+}
+
+func (j *JSONSource) Name() string { return "json:" + j.url }
+
+func (j *JSONSource) Fetch() (map[string]float64, error) {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected a status of 200, but got %d", resp.StatusCode)
+	}
+
+	rates := map[string]float64{}
+	if err := json.NewDecoder(resp.Body).Decode(&rates); err != nil {
+		return nil, err
+	}
+
+	if _, ok := rates["EUR"]; !ok {
+		rates["EUR"] = 1
+	}
+
+	return rates, nil
+}
+
+// FileSource reads rates from a static JSON file on disk. It is intended for
+// tests and for running the service offline, with no network dependency.
+type FileSource struct {
+	path string
+}
+
+// NewFileSource creates a RateSource backed by a local JSON file.
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+func (f *FileSource) Name() string { return "file:" + f.path }
+
+func (f *FileSource) Fetch() (map[string]float64, error) {
+	body, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := map[string]float64{}
+	if err := json.Unmarshal(body, &rates); err != nil {
+		return nil, err
+	}
+
+	if _, ok := rates["EUR"]; !ok {
+		rates["EUR"] = 1
+	}
+
+	return rates, nil
+}
+
+// MultiSource tries each underlying RateSource in order, failing over to the
+// next one when the current source errors, and tracks the health of each so
+// it can be reported upstream via ExchangeRates.Health().
+type MultiSource struct {
+	log     hclog.Logger
+	sources []RateSource
+
+	mu     sync.RWMutex
+	health map[string]SourceHealth
+}
+
+// NewMultiSource composes one or more RateSources into a single RateSource
+// with failover. Sources are tried in the order given.
+func NewMultiSource(l hclog.Logger, sources ...RateSource) *MultiSource {
+	return &MultiSource{
+		log:     l,
+		sources: sources,
+		health:  map[string]SourceHealth{},
+	}
+}
+
+func (m *MultiSource) Name() string { return "multi" }
+
+func (m *MultiSource) Fetch() (map[string]float64, error) {
+	var lastErr error
+
+	for _, s := range m.sources {
+		rates, err := s.Fetch()
+		m.record(s.Name(), err)
+
+		if err != nil {
+			m.log.Warn("Rate source failed, trying next", "source", s.Name(), "error", err)
+			lastErr = err
+			continue
+		}
+
+		return rates, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no rate sources configured")
+	}
+
+	return nil, fmt.Errorf("all rate sources failed: %w", lastErr)
+}
+
+func (m *MultiSource) record(name string, err error) {
+	h := SourceHealth{Name: name, Healthy: err == nil, CheckedAt: time.Now()}
+	if err != nil {
+		h.LastError = err.Error()
+	}
+
+	m.mu.Lock()
+	m.health[name] = h
+	m.mu.Unlock()
+}
+
+// Health returns the last known state of every underlying source.
+func (m *MultiSource) Health() []SourceHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]SourceHealth, 0, len(m.health))
+	for _, h := range m.health {
+		out = append(out, h)
+	}
+
+	return out
+}
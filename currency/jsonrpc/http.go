@@ -0,0 +1,69 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// Handler serves JSON-RPC 2.0 requests over plain HTTP. It supports both a
+// single request object and a batch (a JSON array of request objects);
+// currency_subscribe/currency_unsubscribe are rejected here since HTTP has
+// no way to push notifications back to the caller - use WebsocketHandler for
+// those.
+type Handler struct {
+	svc *Service
+}
+
+// NewHandler creates an http.Handler that dispatches JSON-RPC requests to svc.
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	body := bytes.TrimSpace(readAll(r))
+	if len(body) == 0 {
+		writeJSON(rw, newResponse(nil, nil, newError(ErrCodeParseError, "empty request body")))
+		return
+	}
+
+	if body[0] == '[' {
+		var reqs []*Request
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			writeJSON(rw, newResponse(nil, nil, newError(ErrCodeParseError, err.Error())))
+			return
+		}
+
+		resps := make([]*Response, 0, len(reqs))
+		for _, req := range reqs {
+			resps = append(resps, h.svc.dispatch(req))
+		}
+
+		writeJSON(rw, resps)
+		return
+	}
+
+	var req Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(rw, newResponse(nil, nil, newError(ErrCodeParseError, err.Error())))
+		return
+	}
+
+	writeJSON(rw, h.svc.dispatch(&req))
+}
+
+func readAll(r *http.Request) []byte {
+	defer r.Body.Close()
+
+	buf := &bytes.Buffer{}
+	buf.ReadFrom(r.Body)
+
+	return buf.Bytes()
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	enc := json.NewEncoder(rw)
+	enc.Encode(v)
+}
@@ -0,0 +1,186 @@
+// Package jsonrpc exposes the currency service over JSON-RPC 2.0, for
+// clients (browsers, scripts) that can't or don't want to speak gRPC. It
+// mounts alongside the gRPC server and reuses server.Currency's subscription
+// bookkeeping for the pub/sub rate stream.
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"product.com/product-microservice/currency/data"
+	protos "product.com/product-microservice/currency/protos/currency"
+	"product.com/product-microservice/currency/server"
+)
+
+const Version = "2.0"
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	ErrCodeParseError     = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternalError  = -32603
+)
+
+// Request is a single JSON-RPC request or notification (ID is omitted for
+// notifications).
+type Request struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a single JSON-RPC response envelope. Result and Error are
+// mutually exclusive.
+type Response struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC 2.0 error object.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func newError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func newResponse(id json.RawMessage, result interface{}, err *Error) *Response {
+	return &Response{Version: Version, ID: id, Result: result, Error: err}
+}
+
+// rateParams is the shared parameter shape for getRate and subscribe.
+type rateParams struct {
+	Base        string `json:"base"`
+	Destination string `json:"dest"`
+}
+
+// rateResult is the JSON shape of a rate, used both for getRate's result and
+// for currency_subscription notifications.
+type rateResult struct {
+	Base        string  `json:"base"`
+	Destination string  `json:"dest"`
+	Rate        float64 `json:"rate"`
+}
+
+func rateResultFromProto(r *protos.RateResponse) rateResult {
+	return rateResult{Base: r.GetBase().String(), Destination: r.GetDestination().String(), Rate: r.GetRate()}
+}
+
+// errorFromStatus converts a gRPC status error (as returned by server.Currency
+// and server.ValidateRateRequest) to the equivalent JSON-RPC error, so both
+// transports reject a request the same way instead of jsonrpc re-deriving
+// its own validation.
+func errorFromStatus(err error) *Error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return newError(ErrCodeInternalError, err.Error())
+	}
+
+	if st.Code() == codes.InvalidArgument {
+		return newError(ErrCodeInvalidParams, st.Message())
+	}
+
+	return newError(ErrCodeInternalError, st.Message())
+}
+
+// Service binds the JSON-RPC method set to the currency server and its
+// subscription registry. It is transport agnostic; Handler and
+// WebsocketHandler wrap it for HTTP and WebSocket respectively.
+type Service struct {
+	currency *server.Currency
+	rates    *data.ExchangeRates
+	log      hclog.Logger
+}
+
+// NewService creates a Service bound to the given currency server.
+func NewService(c *server.Currency, r *data.ExchangeRates, l hclog.Logger) *Service {
+	return &Service{currency: c, rates: r, log: l}
+}
+
+func parseCurrency(s string) (protos.Currency, bool) {
+	v, ok := protos.Currency_value[s]
+	return protos.Currency(v), ok
+}
+
+func (s *Service) getRate(params json.RawMessage) (interface{}, *Error) {
+	var p rateParams
+	if len(params) == 0 {
+		return nil, newError(ErrCodeInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, newError(ErrCodeInvalidParams, "invalid params: "+err.Error())
+	}
+
+	base, ok := parseCurrency(p.Base)
+	if !ok {
+		return nil, newError(ErrCodeInvalidParams, "unknown base currency "+p.Base)
+	}
+	dest, ok := parseCurrency(p.Destination)
+	if !ok {
+		return nil, newError(ErrCodeInvalidParams, "unknown destination currency "+p.Destination)
+	}
+
+	rr := &protos.RateRequest{Base: base, Destination: dest}
+
+	resp, err := s.currency.GetRate(context.Background(), rr)
+	if err != nil {
+		return nil, errorFromStatus(err)
+	}
+
+	return rateResultFromProto(resp), nil
+}
+
+// sourceHealth is the JSON shape of a data.SourceHealth entry.
+type sourceHealth struct {
+	Name      string `json:"name"`
+	Healthy   bool   `json:"healthy"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+func (s *Service) getHealth() (interface{}, *Error) {
+	sources := s.rates.Health()
+
+	out := make([]sourceHealth, 0, len(sources))
+	for _, h := range sources {
+		out = append(out, sourceHealth{Name: h.Name, Healthy: h.Healthy, LastError: h.LastError})
+	}
+
+	return out, nil
+}
+
+// dispatch runs a single request that does not require a persistent
+// connection (i.e. everything but subscribe/unsubscribe, which are handled
+// by the WebSocket transport directly since they need a RateSender to push
+// notifications to).
+func (s *Service) dispatch(req *Request) *Response {
+	if req.Version != Version {
+		return newResponse(req.ID, nil, newError(ErrCodeInvalidRequest, "jsonrpc version must be 2.0"))
+	}
+
+	switch req.Method {
+	case "currency_getRate":
+		result, rpcErr := s.getRate(req.Params)
+		return newResponse(req.ID, result, rpcErr)
+	case "currency_health":
+		result, rpcErr := s.getHealth()
+		return newResponse(req.ID, result, rpcErr)
+	case "currency_subscribe", "currency_unsubscribe":
+		return newResponse(req.ID, nil, newError(ErrCodeInvalidRequest, req.Method+" requires a WebSocket connection"))
+	default:
+		return newResponse(req.ID, nil, newError(ErrCodeMethodNotFound, "unknown method "+req.Method))
+	}
+}
@@ -0,0 +1,205 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	protos "product.com/product-microservice/currency/protos/currency"
+	"product.com/product-microservice/currency/server"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Rate subscriptions are read only; allow cross-origin browser clients.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscriptionNotification is the params shape of a currency_subscription
+// notification.
+type subscriptionNotification struct {
+	Subscription string     `json:"subscription"`
+	Result       rateResult `json:"result"`
+}
+
+// wsConn wraps a *websocket.Conn so it can be used as a server.RateSender
+// and from multiple goroutines (gorilla/websocket only allows one writer at
+// a time).
+type wsConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (w *wsConn) Send(msg *protos.StreamingRateResponse) error {
+	rr, ok := msg.Message.(*protos.StreamingRateResponse_RateResponse)
+	if !ok {
+		// Errors from the subscription registry are gRPC specific; WS
+		// subscribers only care about rate updates.
+		return nil
+	}
+
+	return w.notify("currency_subscription", subscriptionNotification{
+		Result: rateResultFromProto(rr.RateResponse),
+	})
+}
+
+func (w *wsConn) notify(method string, params interface{}) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.conn.WriteJSON(&Request{Version: Version, Method: method, Params: b})
+}
+
+func (w *wsConn) writeResponse(resp *Response) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.conn.WriteJSON(resp)
+}
+
+// wsSession tracks the subscriptions created over a single WebSocket
+// connection so they can all be torn down on disconnect.
+type wsSession struct {
+	svc  *Service
+	conn *wsConn
+
+	mu   sync.Mutex
+	subs map[string]*protos.RateRequest
+	next int
+}
+
+func (s *wsSession) nextID() string {
+	s.next++
+	return fmt.Sprintf("%d", s.next)
+}
+
+func (s *wsSession) subscribe(params json.RawMessage) (interface{}, *Error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(params, &raw); err != nil || len(raw) != 2 {
+		return nil, newError(ErrCodeInvalidParams, `expected params ["rates", {"base":..,"dest":..}]`)
+	}
+
+	var channel string
+	if err := json.Unmarshal(raw[0], &channel); err != nil || channel != "rates" {
+		return nil, newError(ErrCodeInvalidParams, `unsupported subscription channel, only "rates" is supported`)
+	}
+
+	var p rateParams
+	if err := json.Unmarshal(raw[1], &p); err != nil {
+		return nil, newError(ErrCodeInvalidParams, "invalid params: "+err.Error())
+	}
+
+	base, ok := parseCurrency(p.Base)
+	if !ok {
+		return nil, newError(ErrCodeInvalidParams, "unknown base currency "+p.Base)
+	}
+	dest, ok := parseCurrency(p.Destination)
+	if !ok {
+		return nil, newError(ErrCodeInvalidParams, "unknown destination currency "+p.Destination)
+	}
+
+	rr := &protos.RateRequest{Base: base, Destination: dest}
+
+	// Reject base == destination the same way Currency.GetRate does, so a
+	// subscription can't be registered for a pair no transport would ever
+	// return a rate for.
+	if err := server.ValidateRateRequest(rr); err != nil {
+		return nil, errorFromStatus(err)
+	}
+
+	s.mu.Lock()
+	id := s.nextID()
+	s.subs[id] = rr
+	s.mu.Unlock()
+
+	s.svc.currency.Subscribe(s.conn, rr)
+
+	return id, nil
+}
+
+func (s *wsSession) unsubscribe(params json.RawMessage) (interface{}, *Error) {
+	var ids []string
+	if err := json.Unmarshal(params, &ids); err != nil || len(ids) != 1 {
+		return nil, newError(ErrCodeInvalidParams, "expected params [subscriptionID]")
+	}
+	id := ids[0]
+
+	s.mu.Lock()
+	rr, ok := s.subs[id]
+	delete(s.subs, id)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, newError(ErrCodeInvalidParams, "unknown subscription id "+id)
+	}
+
+	// Drop only this base/dest pair from the shared registry; the connection
+	// itself (and any other subscriptions on it) stays live.
+	s.svc.currency.RemoveSubscription(s.conn, rr.GetBase(), rr.GetDestination())
+
+	return true, nil
+}
+
+func (s *wsSession) dispatch(req *Request) *Response {
+	switch req.Method {
+	case "currency_subscribe":
+		result, rpcErr := s.subscribe(req.Params)
+		return newResponse(req.ID, result, rpcErr)
+	case "currency_unsubscribe":
+		result, rpcErr := s.unsubscribe(req.Params)
+		return newResponse(req.ID, result, rpcErr)
+	default:
+		return s.svc.dispatch(req)
+	}
+}
+
+// WebsocketHandler upgrades HTTP connections and speaks JSON-RPC 2.0 over
+// the resulting WebSocket, supporting currency_subscribe/unsubscribe in
+// addition to the request/response methods Handler serves.
+type WebsocketHandler struct {
+	svc *Service
+}
+
+// NewWebsocketHandler creates an http.Handler that upgrades to a WebSocket
+// JSON-RPC transport.
+func NewWebsocketHandler(svc *Service) *WebsocketHandler {
+	return &WebsocketHandler{svc: svc}
+}
+
+func (h *WebsocketHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		h.svc.log.Error("Unable to upgrade websocket connection", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	session := &wsSession{
+		svc:  h.svc,
+		conn: &wsConn{conn: conn},
+		subs: map[string]*protos.RateRequest{},
+	}
+	defer h.svc.currency.Unsubscribe(session.conn)
+
+	for {
+		var req Request
+		if err := conn.ReadJSON(&req); err != nil {
+			h.svc.log.Debug("WebSocket client disconnected", "error", err)
+			return
+		}
+
+		resp := session.dispatch(&req)
+		if err := session.conn.writeResponse(resp); err != nil {
+			h.svc.log.Error("Unable to write websocket response", "error", err)
+			return
+		}
+	}
+}
@@ -1,28 +1,101 @@
 package main
 
 import (
+	"context"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"product.com/product-microservice/currency/data"
+	"product.com/product-microservice/currency/jsonrpc"
 	protos "product.com/product-microservice/currency/protos/currency"
 	"product.com/product-microservice/currency/server"
 )
 
+// gracefulStopTimeout bounds how long gs.GracefulStop is given to drain
+// in-flight RPCs (mostly long lived SubscribeRates streams) before falling
+// back to Stop.
+const gracefulStopTimeout = 10 * time.Second
+
 func main() {
 	log := hclog.Default()
-	gs := grpc.NewServer()
-	cs := server.NewCurrency(log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source := data.NewMultiSource(
+		log.Named("rate-source"),
+		data.NewECBSource(log.Named("ecb-source"), "/tmp/ecb-rates-cache.xml"),
+		data.NewFileSource("/etc/product-microservice/fallback-rates.json"),
+	)
+
+	rates, err := data.NewRates(log.Named("rates"), source)
+	if err != nil {
+		log.Error("Unable to fetch initial rates", "error", err)
+	}
+
+	gs := grpc.NewServer(
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    30 * time.Second,
+			Timeout: 10 * time.Second,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             20 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	)
+	cs := server.NewCurrency(ctx, rates, log)
 
 	protos.RegisterCurrencyServer(gs, cs)
 
-	l, err := net.Listen("tcp", ":9092")
+	rpc := jsonrpc.NewService(cs, rates, log.Named("jsonrpc"))
+	mux := http.NewServeMux()
+	mux.Handle("/rpc", jsonrpc.NewHandler(rpc))
+	mux.Handle("/rpc/ws", jsonrpc.NewWebsocketHandler(rpc))
 
+	rpcServer := &http.Server{Addr: ":9094", Handler: mux}
+	go func() {
+		if err := rpcServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("JSON-RPC gateway stopped", "error", err)
+		}
+	}()
+
+	l, err := net.Listen("tcp", ":9092")
 	if err != nil {
 		log.Error("Unable to listen", "error", err)
 		os.Exit(1)
 	}
 
-	gs.Serve(l)
-}
\ No newline at end of file
+	go func() {
+		if err := gs.Serve(l); err != nil {
+			log.Error("gRPC server stopped", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Info("Shutdown signal received, draining connections")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracefulStopTimeout)
+	defer cancel()
+
+	rpcServer.Shutdown(shutdownCtx)
+
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		log.Warn("Graceful stop timed out, forcing shutdown")
+		gs.Stop()
+	}
+}
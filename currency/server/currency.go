@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"time"
 
@@ -12,51 +13,149 @@ import (
 	protos "product.com/product-microservice/currency/protos/currency"
 )
 
+// RateSender is implemented by anything that can receive streaming rate
+// updates. The gRPC stream returned by SubscribeRates satisfies this
+// naturally; it also lets other transports (e.g. the jsonrpc package) share
+// the same subscription bookkeeping instead of reimplementing it.
+type RateSender interface {
+	Send(*protos.StreamingRateResponse) error
+}
+
+// idleStreamTimeout terminates a SubscribeRates stream that has seen neither
+// a client message nor a rate push for this long, so a client that vanished
+// without closing its TCP connection doesn't pin a goroutine forever.
+const idleStreamTimeout = 5 * time.Minute
+
+// sendTimeout bounds how long handleUpdates will wait on a single
+// subscriber's Send before giving up on that tick, so one stuck client can't
+// block updates to every other subscriber.
+const sendTimeout = 2 * time.Second
+
 // Currency is a gRPC server it implements the methods defined by the CurrencyServer interface
 type Currency struct {
-	rates *data.ExchangeRates
-	log hclog.Logger
-	subscriptions map[protos.Currency_SubscribeRatesServer][]*protos.RateRequest
+	rates         *data.ExchangeRates
+	log           hclog.Logger
+	subscriptions *subscriptionRegistry
 }
 
-// NewCurrency creates a new Currency server
-func NewCurrency(r *data.ExchangeRates ,l hclog.Logger) *Currency {
-	c := &Currency{r,l, make(map[protos.Currency_SubscribeRatesServer][]*protos.RateRequest)}
-	go c.handleUpdates()
+// NewCurrency creates a new Currency server. The rate refresh loop it starts
+// runs until ctx is cancelled, e.g. by the graceful shutdown sequence in
+// main.
+func NewCurrency(ctx context.Context, r *data.ExchangeRates, l hclog.Logger) *Currency {
+	c := &Currency{r, l, newSubscriptionRegistry()}
+	go c.handleUpdates(ctx)
 
 	return c
 }
 
-func (c *Currency) handleUpdates(){
-	ru := c.rates.MonitorRates(5* time.Second)
-		for range ru {
-			c.log.Info("Got Updated rates")
-
-			// loop over subscribed clients
-			for k, v := range c.subscriptions {
-
-				//loop over subscribed rates
-				for _, rr := range v {
-					r, err := c.rates.GetRate(rr.GetBase().String(), rr.GetDestination().String())
-					if err != nil {
-						c.log.Error("Unable to get update rate", "base" ,rr.GetBase().String(), "destination", rr.GetDestination().String())
-					}
-
-					err = k.Send(
-						&protos.StreamingRateResponse{
-							Message: &protos.StreamingRateResponse_RateResponse{
-								RateResponse: &protos.RateResponse{Base: rr.Base, Destination: rr.Destination, Rate: r},
-							},
-						},)
-						
-					if err != nil {
-						c.log.Error("Unable to get update rate", "base" ,rr.GetBase().String(), "destination", rr.GetDestination().String())
-					}
+// Subscribe registers sender for updates to the given base/destination pairs
+// so non-gRPC transports (e.g. jsonrpc) can reuse the same broadcast loop
+// that SubscribeRates uses.
+func (c *Currency) Subscribe(sender RateSender, rr ...*protos.RateRequest) {
+	for _, r := range rr {
+		c.subscriptions.Add(sender, r)
+	}
+}
+
+// Unsubscribe removes sender from the subscription registry entirely.
+func (c *Currency) Unsubscribe(sender RateSender) {
+	c.subscriptions.RemoveSender(sender)
+}
+
+// RemoveSubscription drops a single base/destination pair from sender's
+// subscriptions, leaving any others it holds untouched. This is what lets a
+// transport like jsonrpc honor a per-subscription unsubscribe without
+// dropping the whole connection.
+func (c *Currency) RemoveSubscription(sender RateSender, base, dest protos.Currency) {
+	c.subscriptions.Remove(sender, base, dest)
+}
+
+func (c *Currency) handleUpdates(ctx context.Context) {
+	ru := c.rates.MonitorRates(ctx, 5*time.Second)
+	for u := range ru {
+		switch u.State {
+		case data.StateStale:
+			c.log.Warn("Rate refresh failed, serving stale rates", "error", u.Err)
+			continue
+		case data.StateCircuitOpen:
+			c.log.Warn("Rate source circuit breaker open, pausing refresh")
+			continue
+		case data.StateCircuitClosed:
+			c.log.Info("Rate source circuit breaker closed, resuming refresh")
+			continue
+		}
+
+		c.log.Info("Got Updated rates")
+
+		// loop over subscribed clients
+		for k, v := range c.subscriptions.Snapshot() {
+
+			//loop over subscribed rates
+			for _, rr := range v {
+				r, err := c.rates.GetRate(rr.GetBase().String(), rr.GetDestination().String())
+				if err != nil {
+					c.log.Error("Unable to get update rate", "base", rr.GetBase().String(), "destination", rr.GetDestination().String())
 				}
-			}
 
+				err = c.sendWithDeadline(k,
+					&protos.StreamingRateResponse{
+						Message: &protos.StreamingRateResponse_RateResponse{
+							RateResponse: &protos.RateResponse{Base: rr.Base, Destination: rr.Destination, Rate: r},
+						},
+					})
+
+				if err != nil {
+					c.log.Error("Unable to get update rate", "base", rr.GetBase().String(), "destination", rr.GetDestination().String())
+					continue
+				}
 
+				c.subscriptions.TouchIdleTimer(k, idleStreamTimeout)
+			}
 		}
+
+	}
+}
+
+// sendWithDeadline bounds a single Send call to sendTimeout so a subscriber
+// whose stream is wedged (a slow reader, a dead TCP peer that hasn't timed
+// out yet) can't hold up the broadcast to everyone else.
+func (c *Currency) sendWithDeadline(k RateSender, msg *protos.StreamingRateResponse) error {
+	result := make(chan error, 1)
+	go func() { result <- k.Send(msg) }()
+
+	dt := newDeadlineTimer(sendTimeout, func() {})
+	defer dt.Stop()
+
+	select {
+	case err := <-result:
+		return err
+	case <-dt.Done():
+		return fmt.Errorf("send to subscriber timed out after %s", sendTimeout)
+	}
+}
+
+// ValidateRateRequest rejects a RateRequest whose base and destination
+// currency are identical. GetRate enforces this itself; it's exported
+// separately so other transports (e.g. jsonrpc's getRate and subscribe) can
+// apply the same rejection without going through a full GetRate call.
+func ValidateRateRequest(rr *protos.RateRequest) error {
+	if rr.Destination != rr.Base {
+		return nil
+	}
+
+	grpcError := status.Newf(
+		codes.InvalidArgument,
+		"Base currency %s cannot be same as the destination currency",
+		rr.Base.String(),
+		rr.Destination.String(),
+	)
+
+	err, wde := grpcError.WithDetails(rr)
+	if wde != nil {
+		return wde
+	}
+
+	return err.Err()
 }
 
 // GetRate implements the CurrencyServer GetRate method and returns the currency exchange rate
@@ -64,20 +163,8 @@ func (c *Currency) handleUpdates(){
 func (c *Currency) GetRate(ctx context.Context, rr *protos.RateRequest) (*protos.RateResponse, error) {
 	c.log.Info("Handle request for GetRate", "base", rr.GetBase(), "dest", rr.GetDestination())
 
-	if rr.Destination == rr.Base {
-		grpcError := status.Newf(
-			codes.InvalidArgument,
-			"Base currency %s cannot be same as the destination currency",
-			rr.Base.String(),
-			rr.Destination.String(),
-		)
-
-		err , wde := grpcError.WithDetails(rr)
-		if wde != nil {
-			return nil, wde
-		}
-
-		return nil, err.Err()
+	if err := ValidateRateRequest(rr); err != nil {
+		return nil, err
 	}
 
 	rate, err := c.rates.GetRate(rr.GetBase().String(), rr.GetDestination().String())
@@ -85,67 +172,115 @@ func (c *Currency) GetRate(ctx context.Context, rr *protos.RateRequest) (*protos
 		return nil, err
 	}
 
-	return &protos.RateResponse{Base:rr.Base, Destination:rr.Destination, Rate: rate}, nil
+	return &protos.RateResponse{Base: rr.Base, Destination: rr.Destination, Rate: rate}, nil
 }
 
+// GetHealth implements the CurrencyServer GetHealth method, surfacing the
+// state of every underlying data.RateSource so a caller can tell, e.g.,
+// that a MultiSource has failed over to its fallback.
+func (c *Currency) GetHealth(ctx context.Context, req *protos.HealthRequest) (*protos.HealthResponse, error) {
+	sources := c.rates.Health()
+
+	resp := &protos.HealthResponse{Sources: make([]*protos.SourceHealth, 0, len(sources))}
+	for _, s := range sources {
+		resp.Sources = append(resp.Sources, &protos.SourceHealth{
+			Name:      s.Name,
+			Healthy:   s.Healthy,
+			LastError: s.LastError,
+		})
+	}
+
+	return resp, nil
+}
+
+// SubscribeRates implements the bidirectional streaming half of the
+// protocol. Each message on the stream is a subscribe, unsubscribe or
+// replace request; the client never needs to reconnect to change what it's
+// watching. A stream that sees neither a client message nor a rate push for
+// idleStreamTimeout is terminated with codes.DeadlineExceeded so a client
+// that vanished without closing its connection doesn't pin this goroutine
+// forever.
 func (c *Currency) SubscribeRates(src protos.Currency_SubscribeRatesServer) error {
+	ctx, cancel := context.WithCancel(src.Context())
+	defer cancel()
 
-	// handle client messages
-	for {
-		rr, err := src.Recv()
-		if err == io.EOF {
-			c.log.Info("Client has closed connection")
-			break
+	idle := newDeadlineTimer(idleStreamTimeout, cancel)
+	defer idle.Stop()
+	c.subscriptions.SetIdleTimer(src, idle)
+	defer c.subscriptions.RemoveSender(src)
+
+	recvCh := make(chan *protos.StreamingRateRequest)
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			req, err := src.Recv()
+			if err != nil {
+				select {
+				case errCh <- err:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			select {
+			case recvCh <- req:
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.Error(codes.DeadlineExceeded, "subscription idle timeout exceeded")
+
+		case err := <-errCh:
+			if err == io.EOF {
+				c.log.Info("Client has closed connection")
+				return nil
+			}
 
-		if err != nil {
 			c.log.Error("Unable to read from client", "error", err)
 			return err
-		}
 
-		c.log.Info("Handle Client request", "request", rr)
-		
-		rrs, ok := c.subscriptions[src]
-		if !ok {
-			rrs = []*protos.RateRequest{}
-		}
-
-		// check that subscription does not exists
-		var validationError *status.Status
-		for _, v := range rrs {
-			if v.Base == rr.Base && v.Destination == rr.Destination {
-				// subscription exists return errors
-				validationError= status.Newf(
-					codes.AlreadyExists,
-					"Unable to subscribe for currency as subscriptions already exits")
-				
-				// add the original request as Metadata
-				validationError, err = validationError.WithDetails(rr)
-				if err != nil {
-					c.log.Error("Unable to add metadata to error", "error", err)
-					break
-				}
+		case req := <-recvCh:
+			idle.Reset(idleStreamTimeout)
+			c.log.Info("Handle Client request", "request", req)
 
-				break
+			switch msg := req.Message.(type) {
+			case *protos.StreamingRateRequest_Subscribe:
+				c.handleSubscribe(src, msg.Subscribe)
+			case *protos.StreamingRateRequest_Unsubscribe:
+				c.subscriptions.Remove(src, msg.Unsubscribe.GetBase(), msg.Unsubscribe.GetDestination())
+			case *protos.StreamingRateRequest_Replace:
+				c.subscriptions.Replace(src, msg.Replace.GetRequests())
 			}
 		}
+	}
+}
 
-		// if a validation error return error and continue processing
-		if validationError != nil {
-			src.Send(
-				&protos.StreamingRateResponse{
-					Message: &protos.StreamingRateResponse_Error{
-						Error: validationError.Proto(),
-					},
-				},
-			)
-			continue
-		}
+func (c *Currency) handleSubscribe(src protos.Currency_SubscribeRatesServer, rr *protos.RateRequest) {
+	if c.subscriptions.Add(src, rr) {
+		return
+	}
+
+	// subscription already exists, return a validation error
+	validationError := status.Newf(
+		codes.AlreadyExists,
+		"Unable to subscribe for currency as subscriptions already exits")
 
-		// all ok
-		rrs = append(rrs, rr)
-		c.subscriptions[src] = rrs
+	validationError, err := validationError.WithDetails(rr)
+	if err != nil {
+		c.log.Error("Unable to add metadata to error", "error", err)
+		return
 	}
 
-	return nil
-}
\ No newline at end of file
+	src.Send(
+		&protos.StreamingRateResponse{
+			Message: &protos.StreamingRateResponse_Error{
+				Error: validationError.Proto(),
+			},
+		},
+	)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// deadlineTimer fires onExpire once, after d, unless Stop is called first.
+// It backs both SubscribeRates' idle-stream timeout and the per-subscriber
+// send timeout in handleUpdates, so a slow or vanished client can be bounded
+// without either call site reimplementing the cancel-channel dance.
+type deadlineTimer struct {
+	timer *time.Timer
+	done  chan struct{}
+	once  sync.Once
+}
+
+// newDeadlineTimer starts a timer that calls onExpire after d and then
+// closes the channel returned by Done.
+func newDeadlineTimer(d time.Duration, onExpire func()) *deadlineTimer {
+	dt := &deadlineTimer{done: make(chan struct{})}
+	dt.timer = time.AfterFunc(d, func() {
+		onExpire()
+		dt.once.Do(func() { close(dt.done) })
+	})
+
+	return dt
+}
+
+// Reset postpones the deadline by d, as long as it hasn't already fired.
+func (dt *deadlineTimer) Reset(d time.Duration) {
+	select {
+	case <-dt.done:
+		return
+	default:
+	}
+
+	dt.timer.Reset(d)
+}
+
+// Stop cancels the deadline so onExpire never runs. Safe to call more than
+// once.
+func (dt *deadlineTimer) Stop() {
+	dt.timer.Stop()
+	dt.once.Do(func() { close(dt.done) })
+}
+
+// Done returns a channel that's closed once the deadline fires or Stop is
+// called.
+func (dt *deadlineTimer) Done() <-chan struct{} {
+	return dt.done
+}
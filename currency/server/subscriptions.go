@@ -0,0 +1,115 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	protos "product.com/product-microservice/currency/protos/currency"
+)
+
+// subscriptionRegistry tracks, for every connected RateSender, the set of
+// base/destination pairs it wants updates for. Unlike a plain map it is safe
+// for concurrent use: SubscribeRates mutates it from a per-connection
+// goroutine while handleUpdates reads it from the broadcast loop.
+type subscriptionRegistry struct {
+	mu   sync.RWMutex
+	subs map[RateSender][]*protos.RateRequest
+	idle map[RateSender]*deadlineTimer
+}
+
+func newSubscriptionRegistry() *subscriptionRegistry {
+	return &subscriptionRegistry{
+		subs: map[RateSender][]*protos.RateRequest{},
+		idle: map[RateSender]*deadlineTimer{},
+	}
+}
+
+// SetIdleTimer associates dt with sender so TouchIdleTimer (called from
+// handleUpdates on a successful broadcast) can postpone it alongside
+// SubscribeRates resetting it on every Recv.
+func (r *subscriptionRegistry) SetIdleTimer(sender RateSender, dt *deadlineTimer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.idle[sender] = dt
+}
+
+// TouchIdleTimer postpones sender's idle deadline, if it has one.
+func (r *subscriptionRegistry) TouchIdleTimer(sender RateSender, d time.Duration) {
+	r.mu.RLock()
+	dt, ok := r.idle[sender]
+	r.mu.RUnlock()
+
+	if ok {
+		dt.Reset(d)
+	}
+}
+
+// Add appends rr to sender's subscriptions. It reports false without
+// changing anything if sender already has an identical base/dest pair.
+func (r *subscriptionRegistry) Add(sender RateSender, rr *protos.RateRequest) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, v := range r.subs[sender] {
+		if v.Base == rr.Base && v.Destination == rr.Destination {
+			return false
+		}
+	}
+
+	r.subs[sender] = append(r.subs[sender], rr)
+	return true
+}
+
+// Replace overwrites sender's entire subscription set with rrs, so a client
+// can change what it's watching without unsubscribing and resubscribing one
+// pair at a time.
+func (r *subscriptionRegistry) Replace(sender RateSender, rrs []*protos.RateRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.subs[sender] = rrs
+}
+
+// Remove drops a single base/dest pair from sender's subscriptions. It
+// builds a fresh backing array rather than compacting in place, since
+// Snapshot hands out the slice header (not a deep copy) to handleUpdates,
+// which may still be reading it outside of any lock.
+func (r *subscriptionRegistry) Remove(sender RateSender, base, dest protos.Currency) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rrs := r.subs[sender]
+	out := make([]*protos.RateRequest, 0, len(rrs))
+	for _, v := range rrs {
+		if v.Base == base && v.Destination == dest {
+			continue
+		}
+		out = append(out, v)
+	}
+	r.subs[sender] = out
+}
+
+// RemoveSender drops every subscription held by sender, e.g. once its
+// connection has gone away.
+func (r *subscriptionRegistry) RemoveSender(sender RateSender) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.subs, sender)
+	delete(r.idle, sender)
+}
+
+// Snapshot returns a point-in-time copy of the registry so handleUpdates can
+// broadcast without holding the lock for the duration of every Send call.
+func (r *subscriptionRegistry) Snapshot() map[RateSender][]*protos.RateRequest {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make(map[RateSender][]*protos.RateRequest, len(r.subs))
+	for k, v := range r.subs {
+		out[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"sync"
+	"testing"
+
+	protos "product.com/product-microservice/currency/protos/currency"
+)
+
+// fakeSender is a RateSender that just counts how many messages it received,
+// so tests can fan out many concurrent subscribers without a real gRPC
+// stream.
+type fakeSender struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (f *fakeSender) Send(*protos.StreamingRateResponse) error {
+	f.mu.Lock()
+	f.count++
+	f.mu.Unlock()
+
+	return nil
+}
+
+// TestSubscriptionRegistryConcurrent fans out N concurrent subscribers that
+// each subscribe, get replaced, and unsubscribe while a separate goroutine
+// repeatedly snapshots the registry and "sends" to every subscriber, the
+// same access pattern handleUpdates uses against SubscribeRates. Run with
+// -race; it should be clean.
+func TestSubscriptionRegistryConcurrent(t *testing.T) {
+	const subscribers = 50
+	const ticks = 50
+
+	reg := newSubscriptionRegistry()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// simulate handleUpdates' broadcast loop
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < ticks; i++ {
+			for sender, rrs := range reg.Snapshot() {
+				for range rrs {
+					sender.Send(&protos.StreamingRateResponse{})
+				}
+			}
+		}
+
+		close(stop)
+	}()
+
+	for i := 0; i < subscribers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			sender := &fakeSender{}
+			rr := &protos.RateRequest{Base: protos.Currency(i % 2), Destination: protos.Currency((i + 1) % 2)}
+
+			reg.Add(sender, rr)
+			reg.Replace(sender, []*protos.RateRequest{rr})
+			reg.Remove(sender, rr.Base, rr.Destination)
+			reg.RemoveSender(sender)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// BenchmarkSubscriptionRegistrySnapshot measures the cost of broadcasting to
+// a large subscriber set, the hot path exercised by handleUpdates.
+func BenchmarkSubscriptionRegistrySnapshot(b *testing.B) {
+	reg := newSubscriptionRegistry()
+
+	for i := 0; i < 1000; i++ {
+		sender := &fakeSender{}
+		reg.Add(sender, &protos.RateRequest{
+			Base:        protos.Currency(i % 2),
+			Destination: protos.Currency((i + 1) % 2),
+		})
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for sender, rrs := range reg.Snapshot() {
+			for range rrs {
+				sender.Send(&protos.StreamingRateResponse{})
+			}
+		}
+	}
+}
@@ -1,62 +1,233 @@
 package handlers
 
 import (
+	"compress/flate"
 	"compress/gzip"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
-)
 
+	"github.com/andybalholm/brotli"
+)
 
+// minCompressLength is the smallest response body we'll bother compressing;
+// anything shorter usually ends up bigger once you add the encoding's
+// framing overhead.
+const minCompressLength = 256
+
+// skipContentTypes holds response content types that are already compressed
+// and shouldn't be compressed again.
+var skipContentTypes = map[string]bool{
+	"image/png":        true,
+	"image/jpeg":       true,
+	"image/gif":        true,
+	"application/zip":  true,
+	"application/gzip": true,
+	"video/mp4":        true,
+}
 
 type GzipMiddleware struct {
-
 }
 
-
 func (g *GzipMiddleware) GzipMiddlewareHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.Header.Get("Accept-Encoding"),"gzip") {
-			// create a gziped response
-			wrw := NewWrappedResponseWriter(rw)
-			wrw.Header().Set("Content-Encoding", "gzip")
+		decodeRequestBody(r)
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
 
-			next.ServeHTTP(wrw, r)
-			defer wrw.Flush()
+		wrw := NewWrappedResponseWriter(rw, encoding)
+		defer wrw.Close()
 
+		next.ServeHTTP(wrw, r)
+	})
+}
+
+// decodeRequestBody transparently decompresses a gzip or deflate encoded
+// request body so handlers downstream never need to know whether the client
+// compressed its upload. r.ContentLength is reset to -1 since the decoded
+// body's length is no longer known up front.
+func decodeRequestBody(r *http.Request) {
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
 			return
 		}
 
-		// handle normal
-		next.ServeHTTP(rw, r)
+		r.Body = gr
+		r.ContentLength = -1
+		r.Header.Del("Content-Encoding")
+	case "deflate":
+		r.Body = flate.NewReader(r.Body)
+		r.ContentLength = -1
+		r.Header.Del("Content-Encoding")
+	}
+}
+
+// supportedEncodings maps an encoding name to its preference rank when
+// multiple candidates share the same q-value, lowest rank wins.
+var supportedEncodings = map[string]int{"br": 0, "gzip": 1, "deflate": 2}
+
+// negotiateEncoding parses an Accept-Encoding header with q-values and
+// returns the best supported encoding ("br", "gzip" or "deflate"), or "" if
+// the client doesn't accept any of them.
+func negotiateEncoding(header string) string {
+	type candidate struct {
+		name string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i != -1 {
+			name = strings.TrimSpace(part[:i])
+			if qv := strings.TrimSpace(part[i+1:]); strings.HasPrefix(qv, "q=") {
+				if parsed, err := strconv.ParseFloat(qv[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		if _, ok := supportedEncodings[name]; !ok || q <= 0 {
+			continue
+		}
+
+		candidates = append(candidates, candidate{name, q})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return supportedEncodings[candidates[i].name] < supportedEncodings[candidates[j].name]
 	})
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	return candidates[0].name
 }
 
+// WrappedResponseWriter compresses the response body with the negotiated
+// encoding. The decision to actually compress is deferred until enough of
+// the body has been buffered to know it's worth it: handlers that write a
+// content type we shouldn't double-compress, or fewer than
+// minCompressLength bytes, are passed through uncompressed instead.
 type WrappedResponseWriter struct {
-	rw http.ResponseWriter
-	gw *gzip.Writer
+	rw       http.ResponseWriter
+	encoding string
+
+	statusCode int
+	buf        []byte
+	decided    bool
+	cw         io.WriteCloser
 }
 
-func NewWrappedResponseWriter(rw http.ResponseWriter) *WrappedResponseWriter {
-	gw := gzip.NewWriter(rw)
+func NewWrappedResponseWriter(rw http.ResponseWriter, encoding string) *WrappedResponseWriter {
+	rw.Header().Add("Vary", "Accept-Encoding")
 
-	return &WrappedResponseWriter{
-		rw, gw,
-	}
+	return &WrappedResponseWriter{rw: rw, encoding: encoding, statusCode: http.StatusOK}
 }
 
 func (wr *WrappedResponseWriter) Header() http.Header {
 	return wr.rw.Header()
 }
 
+// WriteHeader only records the status; it isn't sent to the client until
+// decide has worked out whether Content-Encoding applies, since that also
+// changes whether Content-Length is valid.
+func (wr *WrappedResponseWriter) WriteHeader(statusCode int) {
+	wr.statusCode = statusCode
+}
+
 func (wr *WrappedResponseWriter) Write(d []byte) (int, error) {
-	return wr.gw.Write(d)
+	if wr.decided {
+		if wr.cw != nil {
+			return wr.cw.Write(d)
+		}
+		return wr.rw.Write(d)
+	}
+
+	wr.buf = append(wr.buf, d...)
+	if len(wr.buf) < minCompressLength {
+		return len(d), nil
+	}
+
+	wr.decide()
+
+	return len(d), wr.flushBuf()
 }
 
-func (wr *WrappedResponseWriter) WriteHeader(statusCode int) {
-	wr.rw.WriteHeader(statusCode)
+func (wr *WrappedResponseWriter) decide() {
+	wr.decided = true
+
+	if skipContentTypes[wr.rw.Header().Get("Content-Type")] || len(wr.buf) < minCompressLength {
+		wr.rw.Header().Del("Content-Encoding")
+		wr.rw.WriteHeader(wr.statusCode)
+		return
+	}
+
+	wr.rw.Header().Set("Content-Encoding", wr.encoding)
+	wr.rw.Header().Del("Content-Length")
+	wr.rw.WriteHeader(wr.statusCode)
+	wr.cw = newCompressWriter(wr.rw, wr.encoding)
 }
 
-func (wr *WrappedResponseWriter) Flush(){
-	wr.gw.Flush()
-	wr.gw.Close()
-}
\ No newline at end of file
+func (wr *WrappedResponseWriter) flushBuf() error {
+	buf := wr.buf
+	wr.buf = nil
+
+	if wr.cw != nil {
+		_, err := wr.cw.Write(buf)
+		return err
+	}
+
+	_, err := wr.rw.Write(buf)
+	return err
+}
+
+func newCompressWriter(w io.Writer, encoding string) io.WriteCloser {
+	switch encoding {
+	case "br":
+		return brotli.NewWriter(w)
+	case "deflate":
+		fw, err := flate.NewWriter(w, flate.DefaultCompression)
+		if err != nil {
+			return gzip.NewWriter(w)
+		}
+		return fw
+	default:
+		return gzip.NewWriter(w)
+	}
+}
+
+// Close finalizes the response: it flushes out anything still buffered
+// (for responses shorter than minCompressLength that never went through
+// decide) and closes the underlying compressor exactly once.
+func (wr *WrappedResponseWriter) Close() error {
+	if !wr.decided {
+		wr.decide()
+		if err := wr.flushBuf(); err != nil {
+			return err
+		}
+	}
+
+	if wr.cw == nil {
+		return nil
+	}
+
+	return wr.cw.Close()
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write([]byte(s)); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+
+	var r io.Reader = bytes.NewReader(body)
+	var err error
+
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(r)
+	case "deflate":
+		r = flate.NewReader(r)
+	case "br":
+		r = brotli.NewReader(r)
+	default:
+		t.Fatalf("unsupported encoding %q", encoding)
+	}
+	if err != nil {
+		t.Fatalf("new %s reader: %v", encoding, err)
+	}
+
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read %s body: %v", encoding, err)
+	}
+
+	return string(decoded)
+}
+
+// TestGzipMiddlewareDecodesRequestBody checks that a gzip-encoded request
+// body is transparently decompressed before it reaches the inner handler.
+func TestGzipMiddlewareDecodesRequestBody(t *testing.T) {
+	const want = "hello from the client"
+
+	var got string
+	inner := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("read request body: %v", err)
+		}
+		got = string(b)
+	})
+
+	mw := &GzipMiddleware{}
+	handler := mw.GzipMiddlewareHandler(inner)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, want)))
+	req.Header.Set("Content-Encoding", "gzip")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != want {
+		t.Errorf("inner handler got body %q, want %q", got, want)
+	}
+}
+
+// TestGzipMiddlewareNegotiatesEncoding exercises a handful of
+// Accept-Encoding/Content-Type combinations end to end and checks that the
+// response is both encoded with the negotiated algorithm and decodes back to
+// the original bytes.
+func TestGzipMiddlewareNegotiatesEncoding(t *testing.T) {
+	body := strings.Repeat("compress me please ", 20) // > minCompressLength
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{"brotli preferred when offered plainly", "br, gzip, deflate", "br"},
+		{"gzip chosen when brotli absent", "gzip, deflate", "gzip"},
+		{"deflate chosen when only option", "deflate", "deflate"},
+		{"q-values override declaration order", "br;q=0.1, gzip;q=0.9", "gzip"},
+		{"q=0 excludes an otherwise preferred encoding", "br;q=0, gzip;q=0.5", "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				rw.Header().Set("Content-Type", "text/plain")
+				rw.Write([]byte(body))
+			})
+
+			mw := &GzipMiddleware{}
+			handler := mw.GzipMiddlewareHandler(inner)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+
+			if got := decodeBody(t, tt.wantEncoding, rec.Body.Bytes()); got != body {
+				t.Errorf("decoded body = %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+// TestGzipMiddlewareSkipsShortResponses checks that a response shorter than
+// minCompressLength is passed through uncompressed.
+func TestGzipMiddlewareSkipsShortResponses(t *testing.T) {
+	const body = "short"
+
+	inner := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Write([]byte(body))
+	})
+
+	mw := &GzipMiddleware{}
+	handler := mw.GzipMiddlewareHandler(inner)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q, want unset for a short response", got)
+	}
+	if rec.Body.String() != body {
+		t.Errorf("body = %q, want %q", rec.Body.String(), body)
+	}
+}
+
+// TestGzipMiddlewareSkipsCompressedContentTypes checks that a response whose
+// Content-Type is already compressed isn't compressed again, even though it's
+// longer than minCompressLength.
+func TestGzipMiddlewareSkipsCompressedContentTypes(t *testing.T) {
+	body := strings.Repeat("\x00\x01\x02\x03", 100)
+
+	inner := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "image/png")
+		rw.Write([]byte(body))
+	})
+
+	mw := &GzipMiddleware{}
+	handler := mw.GzipMiddlewareHandler(inner)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q, want unset for a skip-listed content type", got)
+	}
+	if rec.Body.String() != body {
+		t.Errorf("body was altered for a skip-listed content type")
+	}
+}